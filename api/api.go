@@ -1,25 +1,53 @@
 package api
 
 import (
+	"context"
 	gohttp "net/http"
 
 	"github.com/bobrnor/MailHog-Server/config"
 	"github.com/gorilla/pat"
 )
 
-func CreateAPI(conf *config.Config, r gohttp.Handler) {
+// CreateAPI wires up the v1, v2 and v3 APIs and fans out messages arriving
+// on conf.MessageChan to each. The fan-out goroutine exits once ctx is
+// done instead of leaking. Each forwarding send waits until its consumer
+// receives or ctx is done; messages are never dropped, so a stalled
+// v1/v2/v3 consumer holds up the fan-out, and with it SMTP intake, until
+// ctx is done.
+//
+// The returned func drains the v3 websocket hub, bounded by the context
+// passed to it; callers should invoke it after cancelling ctx and before
+// exiting the process, so in-flight connections get a chance to close
+// cleanly instead of being killed outright.
+func CreateAPI(ctx context.Context, conf *config.Config, r gohttp.Handler) func(context.Context) {
 	apiv1 := createAPIv1(conf, r.(*pat.Router))
 	apiv2 := createAPIv2(conf, r.(*pat.Router))
-	apiv3 := createAPIv3(conf, r.(*pat.Router))
+	apiv3 := createAPIv3(ctx, conf, r.(*pat.Router))
 
 	go func() {
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case msg := <-conf.MessageChan:
-				apiv1.messageChan <- msg
-				apiv2.messageChan <- msg
-				apiv3.messageChan <- msg
+				select {
+				case apiv1.messageChan <- msg:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case apiv2.messageChan <- msg:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case apiv3.messageChan <- msg:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
 	}()
+
+	return apiv3.Shutdown
 }
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/bobrnor/MailHog-Server/auth"
 	"github.com/bobrnor/MailHog-Server/config"
 	"github.com/bobrnor/MailHog-Server/websockets"
 	"github.com/bobrnor/storage"
@@ -13,6 +16,9 @@ import (
 	"github.com/mailhog/data"
 )
 
+// aclReloadInterval is how often the auth tokens file is checked for changes.
+const aclReloadInterval = 5 * time.Second
+
 // APIv3 implements version 3 of the MailHog API
 //
 // It is currently experimental and may change in future releases.
@@ -21,14 +27,33 @@ type APIv3 struct {
 	config      *config.Config
 	messageChan chan *data.Message
 	wsHub       *websockets.Hub
+	acl         *auth.ACL
 }
 
-func createAPIv3(conf *config.Config, r *pat.Router) *APIv3 {
+func createAPIv3(ctx context.Context, conf *config.Config, r *pat.Router) *APIv3 {
 	log.Println("Creating API v3 with WebPath: " + conf.WebPath)
+
+	acl, err := auth.LoadACL(conf.AuthTokensFile)
+	if err != nil {
+		// A tokens file was explicitly configured but failed to load: fail
+		// closed rather than silently disabling auth. Watch keeps retrying
+		// below, so the server recovers once the file is fixed.
+		log.Printf("[APIv3] failed to load auth tokens file %s: %s, denying all requests until it loads", conf.AuthTokensFile, err)
+		acl = auth.NewDenyAllACL(conf.AuthTokensFile)
+	}
+
+	aclStop := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(aclStop)
+	}()
+	acl.Watch(aclReloadInterval, aclStop)
+
 	apiv3 := &APIv3{
 		config:      conf,
 		messageChan: make(chan *data.Message),
-		wsHub:       websockets.NewHub(),
+		wsHub:       websockets.NewHub(conf.NamespaceExtractor, conf.MessageLogDir, conf.MessageLogRetention),
+		acl:         acl,
 	}
 
 	r.Path(conf.WebPath + "/api/v3/namespaces").Methods("GET").HandlerFunc(apiv3.namespaces)
@@ -44,9 +69,13 @@ func createAPIv3(conf *config.Config, r *pat.Router) *APIv3 {
 
 	r.Path(conf.WebPath + "/api/v3/{namespace}/websocket").Methods("GET").HandlerFunc(apiv3.websocket)
 
+	r.Path(conf.WebPath + "/api/v3/{namespace}/stream").Methods("GET").HandlerFunc(apiv3.stream)
+
 	go func() {
 		for {
 			select {
+			case <-ctx.Done():
+				return
 			case msg := <-apiv3.messageChan:
 				log.Println("Got message in APIv3 websocket channel")
 				apiv3.broadcast(msg)
@@ -57,6 +86,12 @@ func createAPIv3(conf *config.Config, r *pat.Router) *APIv3 {
 	return apiv3
 }
 
+// Shutdown drains the websocket hub, bounded by ctx, so callers can wait
+// for in-flight connections to close cleanly before the process exits.
+func (apiv3 *APIv3) Shutdown(ctx context.Context) {
+	apiv3.wsHub.Shutdown(ctx)
+}
+
 func (apiv3 *APIv3) defaultOptions(w http.ResponseWriter, req *http.Request) {
 	if len(apiv3.config.CORSOrigin) > 0 {
 		w.Header().Add("Access-Control-Allow-Origin", apiv3.config.CORSOrigin)
@@ -97,6 +132,11 @@ func (apiv3 *APIv3) namespaces(w http.ResponseWriter, req *http.Request) {
 
 	apiv3.defaultOptions(w, req)
 
+	t, ok := apiv3.requireToken(w, req)
+	if !ok {
+		return
+	}
+
 	s, ok := apiv3.config.Storage.(storage.StorageWithNamespace)
 	if !ok {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -108,6 +148,8 @@ func (apiv3 *APIv3) namespaces(w http.ResponseWriter, req *http.Request) {
 		panic(err)
 	}
 
+	res = apiv3.allowedNamespaces(t, res)
+
 	bytes, _ := json.Marshal(res)
 	w.Header().Add("Content-Type", "text/json")
 	w.Write(bytes)
@@ -118,8 +160,6 @@ func (apiv3 *APIv3) messages(w http.ResponseWriter, req *http.Request) {
 
 	apiv3.defaultOptions(w, req)
 
-	start, limit := apiv3.getStartLimit(w, req)
-
 	ns := req.URL.Query().Get(":namespace")
 
 	if len(ns) == 0 {
@@ -127,6 +167,17 @@ func (apiv3 *APIv3) messages(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !apiv3.requireNamespaceAccess(w, req, ns) {
+		return
+	}
+
+	if since := req.URL.Query().Get("since"); since != "" {
+		apiv3.messagesSince(w, ns, since)
+		return
+	}
+
+	start, limit := apiv3.getStartLimit(w, req)
+
 	var res messagesResultV3
 
 	s, ok := apiv3.config.Storage.(storage.StorageWithNamespace)
@@ -150,6 +201,23 @@ func (apiv3 *APIv3) messages(w http.ResponseWriter, req *http.Request) {
 	w.Write(bytes)
 }
 
+// messagesSince answers GET /api/v3/{namespace}/messages?since=N by
+// returning messages by sequence number instead of offset, so polling and
+// streaming clients can share the same cursor semantics.
+func (apiv3 *APIv3) messagesSince(w http.ResponseWriter, ns, since string) {
+	seq, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	entries := apiv3.wsHub.Since(ns, seq)
+
+	bytes, _ := json.Marshal(entries)
+	w.Header().Add("Content-Type", "text/json")
+	w.Write(bytes)
+}
+
 func (apiv3 *APIv3) search(w http.ResponseWriter, req *http.Request) {
 	log.Println("[APIv3] GET /api/v3/{namespace}/search")
 
@@ -176,6 +244,10 @@ func (apiv3 *APIv3) search(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !apiv3.requireNamespaceAccess(w, req, ns) {
+		return
+	}
+
 	var res messagesResultV3
 
 	s, ok := apiv3.config.Storage.(storage.StorageWithNamespace)
@@ -210,6 +282,10 @@ func (apiv3 *APIv3) delete_all(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !apiv3.requireNamespaceAccess(w, req, ns) {
+		return
+	}
+
 	s, ok := apiv3.config.Storage.(storage.StorageWithNamespace)
 	if !ok {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -242,6 +318,10 @@ func (apiv3 *APIv3) delete_one(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !apiv3.requireNamespaceAccess(w, req, ns) {
+		return
+	}
+
 	s, ok := apiv3.config.Storage.(storage.StorageWithNamespace)
 	if !ok {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -267,6 +347,10 @@ func (apiv3 *APIv3) websocket(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !apiv3.requireNamespaceAccess(w, req, ns) {
+		return
+	}
+
 	apiv3.wsHub.ServeWithNamespace(ns, w, req)
 }
 
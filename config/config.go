@@ -0,0 +1,119 @@
+package config
+
+import (
+	"flag"
+	"time"
+
+	"github.com/bobrnor/MailHog-Server/namespace"
+	"github.com/bobrnor/storage"
+	"github.com/ian-kent/go-log/log"
+	"github.com/mailhog/data"
+)
+
+// Config holds the runtime configuration shared by the API server and the
+// SMTP intake, so both sides agree on storage and namespace classification.
+type Config struct {
+	APIBindAddr        string
+	SMTPBindAddr       string
+	Hostname           string
+	WebPath            string
+	CORSOrigin         string
+	Storage            storage.Storage
+	MessageChan        chan *data.Message
+	AuthTokensFile     string
+	NamespaceExtractor namespace.Extractor
+
+	// ReadTimeout, WriteTimeout and IdleTimeout are applied to the
+	// http.Server constructed by the server package.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// ShutdownTimeout bounds how long graceful shutdown waits for
+	// in-flight websocket connections to drain before giving up.
+	ShutdownTimeout time.Duration
+
+	// MessageLogDir is where per-namespace replay logs are stored.
+	MessageLogDir string
+	// MessageLogRetention bounds how many buffered messages each
+	// namespace's replay log keeps before truncating the oldest entries.
+	MessageLogRetention int64
+}
+
+var (
+	apiBindAddr    *string
+	smtpBindAddr   *string
+	hostname       *string
+	corsOrigin     *string
+	authTokensFile *string
+
+	readTimeout     *time.Duration
+	writeTimeout    *time.Duration
+	idleTimeout     *time.Duration
+	shutdownTimeout *time.Duration
+
+	messageLogDir       *string
+	messageLogRetention *int64
+
+	namespaceStrategy *string
+	namespaceHeader   *string
+)
+
+// RegisterFlags registers the command line flags used to configure the API.
+func RegisterFlags() {
+	apiBindAddr = flag.String("api-bind-addr", "0.0.0.0:8025", "Bind address for API HTTP server")
+	smtpBindAddr = flag.String("smtp-bind-addr", "0.0.0.0:1025", "Bind address for SMTP server")
+	hostname = flag.String("hostname", "mailhog.example", "Hostname to use in SMTP responses and Received headers")
+	corsOrigin = flag.String("cors-origin", "", "CORS Access-Control-Allow-Origin header")
+	authTokensFile = flag.String("auth-tokens-file", "", "Path to a JSON file mapping bearer tokens to allowed namespace globs")
+
+	readTimeout = flag.Duration("api-read-timeout", 30*time.Second, "HTTP read timeout for the API server")
+	writeTimeout = flag.Duration("api-write-timeout", 30*time.Second, "HTTP write timeout for the API server")
+	idleTimeout = flag.Duration("api-idle-timeout", 120*time.Second, "HTTP idle timeout for the API server")
+	shutdownTimeout = flag.Duration("api-shutdown-timeout", 10*time.Second, "How long to wait for in-flight connections to drain on shutdown")
+
+	messageLogDir = flag.String("message-log-dir", "mailhog-wal", "Directory where per-namespace replay logs are stored")
+	messageLogRetention = flag.Int64("message-log-retention", 10000, "Maximum number of buffered messages kept per namespace's replay log")
+
+	namespaceStrategy = flag.String("namespace-strategy", "xfields", "Namespace extraction strategy: xfields, header, recipient-domain, recipient-tag")
+	namespaceHeader = flag.String("namespace-header", "X-Namespace", "Header name used when -namespace-strategy=header")
+}
+
+// Configure builds a Config from the parsed command line flags.
+func Configure() *Config {
+	return &Config{
+		APIBindAddr:         *apiBindAddr,
+		SMTPBindAddr:        *smtpBindAddr,
+		Hostname:            *hostname,
+		CORSOrigin:          *corsOrigin,
+		MessageChan:         make(chan *data.Message),
+		AuthTokensFile:      *authTokensFile,
+		NamespaceExtractor:  namespaceExtractor(),
+		ReadTimeout:         *readTimeout,
+		WriteTimeout:        *writeTimeout,
+		IdleTimeout:         *idleTimeout,
+		ShutdownTimeout:     *shutdownTimeout,
+		MessageLogDir:       *messageLogDir,
+		MessageLogRetention: *messageLogRetention,
+	}
+}
+
+// namespaceExtractor builds the namespace.Extractor selected by
+// -namespace-strategy. Callers that need a custom strategy (namespace.
+// ExtractorFunc) should overwrite Config.NamespaceExtractor after calling
+// Configure.
+func namespaceExtractor() namespace.Extractor {
+	switch *namespaceStrategy {
+	case "header":
+		return namespace.HeaderExtractor{Header: *namespaceHeader}
+	case "recipient-domain":
+		return namespace.RecipientDomainExtractor{}
+	case "recipient-tag":
+		return namespace.RecipientTagExtractor{}
+	case "xfields", "":
+		return namespace.XFieldsExtractor{}
+	default:
+		log.Printf("[config] unrecognized -namespace-strategy %q, falling back to xfields", *namespaceStrategy)
+		return namespace.XFieldsExtractor{}
+	}
+}
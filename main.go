@@ -1,18 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"os/signal"
+	"syscall"
 
 	gohttp "net/http"
 
 	"github.com/bobrnor/MailHog-Server/api"
 	"github.com/bobrnor/MailHog-Server/config"
+	"github.com/bobrnor/MailHog-Server/server"
 	"github.com/bobrnor/MailHog-Server/smtp"
 	comcfg "github.com/bobrnor/MailHog/config"
 	"github.com/ian-kent/go-log/log"
 	"github.com/mailhog/MailHog-UI/assets"
-	"github.com/mailhog/http"
 )
 
 var conf *config.Config
@@ -30,22 +33,56 @@ func configure() {
 func main() {
 	configure()
 
-	if comconf.AuthFile != "" {
-		http.AuthFile(comconf.AuthFile)
-	}
+	ctx, cancel := context.WithCancel(context.Background())
 
+	var shutdownAPI func(context.Context)
+	var shutdownHTTP func(context.Context) error
 	exitCh = make(chan int)
 	cb := func(r gohttp.Handler) {
-		api.CreateAPI(conf, r)
+		shutdownAPI = api.CreateAPI(ctx, conf, r)
+	}
+	timeouts := server.Timeouts{
+		ReadTimeout:  conf.ReadTimeout,
+		WriteTimeout: conf.WriteTimeout,
+		IdleTimeout:  conf.IdleTimeout,
 	}
-	go http.Listen(conf.APIBindAddr, assets.Asset, exitCh, cb)
+	go server.Listen(conf.APIBindAddr, assets.Asset, comconf.AuthFile, exitCh, timeouts, cb, func(shutdown func(context.Context) error) {
+		shutdownHTTP = shutdown
+	})
 	go smtp.Listen(conf, exitCh)
 
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
 	for {
 		select {
 		case <-exitCh:
 			log.Printf("Received exit signal")
+			shutdown(cancel, &shutdownAPI, &shutdownHTTP, conf)
+			os.Exit(0)
+		case sig := <-sigCh:
+			log.Printf("Received %s, shutting down", sig)
+			shutdown(cancel, &shutdownAPI, &shutdownHTTP, conf)
 			os.Exit(0)
 		}
 	}
 }
+
+// shutdown cancels ctx, then — for each of the API and HTTP server that
+// finished starting up and handed back a drain func — blocks until it
+// has drained or conf.ShutdownTimeout elapses, whichever comes first.
+// This must happen before the process exits, or in-flight connections
+// are killed outright instead of closed gracefully.
+func shutdown(cancel context.CancelFunc, shutdownAPI *func(context.Context), shutdownHTTP *func(context.Context) error, conf *config.Config) {
+	cancel()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer cancelShutdown()
+
+	if *shutdownAPI != nil {
+		(*shutdownAPI)(shutdownCtx)
+	}
+	if *shutdownHTTP != nil {
+		(*shutdownHTTP)(shutdownCtx)
+	}
+}
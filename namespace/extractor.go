@@ -0,0 +1,95 @@
+// Package namespace provides pluggable strategies for deriving the
+// namespace a message belongs to. config.Config exposes the selected
+// Extractor so both the SMTP intake path (storage.StorageWithNamespace
+// writes) and the live broadcast path (api/websockets) can classify
+// messages the same way; callers on both sides must use conf.
+// NamespaceExtractor rather than their own copy to keep persistence and
+// streaming in agreement.
+package namespace
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mailhog/data"
+)
+
+// Extractor derives the namespace a message belongs to. Implementations
+// should return "" when a message doesn't carry enough information to be
+// classified.
+type Extractor interface {
+	Namespace(msg *data.Message) string
+}
+
+// ExtractorFunc adapts a plain func(*data.Message) string to Extractor,
+// for callers that want to supply their own routing logic in code rather
+// than picking one of the built-in strategies.
+type ExtractorFunc func(msg *data.Message) string
+
+// Namespace calls f(msg).
+func (f ExtractorFunc) Namespace(msg *data.Message) string {
+	return f(msg)
+}
+
+// XFieldsExtractor reads the legacy `X-Fields: {"ms":"..."}` JSON header.
+type XFieldsExtractor struct{}
+
+// Namespace implements Extractor.
+func (XFieldsExtractor) Namespace(msg *data.Message) string {
+	xFields, ok := msg.Content.Headers["X-Fields"]
+	if !ok || len(xFields) == 0 {
+		return ""
+	}
+
+	var xFieldJSON struct {
+		Microservice string `json:"ms"`
+	}
+	if err := json.Unmarshal([]byte(xFields[0]), &xFieldJSON); err != nil {
+		return ""
+	}
+	return xFieldJSON.Microservice
+}
+
+// HeaderExtractor reads a plain header, e.g. `X-Namespace: billing`.
+type HeaderExtractor struct {
+	Header string
+}
+
+// Namespace implements Extractor.
+func (e HeaderExtractor) Namespace(msg *data.Message) string {
+	values, ok := msg.Content.Headers[e.Header]
+	if !ok || len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// RecipientDomainExtractor derives the namespace from the first
+// recipient's domain, i.e. namespace = strings.Split(to, "@")[1].
+type RecipientDomainExtractor struct{}
+
+// Namespace implements Extractor.
+func (RecipientDomainExtractor) Namespace(msg *data.Message) string {
+	if len(msg.To) == 0 {
+		return ""
+	}
+	return string(msg.To[0].Domain)
+}
+
+// RecipientTagExtractor derives the namespace from the `+tag` in the
+// first recipient's localpart, e.g. user+tag@host -> "tag".
+type RecipientTagExtractor struct{}
+
+// Namespace implements Extractor.
+func (RecipientTagExtractor) Namespace(msg *data.Message) string {
+	if len(msg.To) == 0 {
+		return ""
+	}
+
+	mailbox := string(msg.To[0].Mailbox)
+	idx := strings.Index(mailbox, "+")
+	if idx == -1 {
+		return ""
+	}
+	return mailbox[idx+1:]
+}
@@ -0,0 +1,119 @@
+// Package server runs the HTTP listener shared by the MailHog UI and API.
+// It exists because the upstream mailhog/http.Listen this replaced hides
+// its *http.Server entirely, so ReadTimeout/WriteTimeout/IdleTimeout can
+// never be applied to it; constructing the server here makes them
+// configurable.
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/pat"
+	"github.com/ian-kent/go-log/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Timeouts bounds the http.Server's deadlines.
+type Timeouts struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+}
+
+// Listen serves assetFn-backed UI files and any routes cb registers on the
+// router, binding bindAddr with timeouts applied. When authFile is
+// non-empty, every request must present HTTP Basic Auth credentials
+// matching a "user:bcrypt-hash" pair in it, one per line — the same
+// format upstream MailHog's -auth-file accepts. ready is called with the
+// server's graceful-shutdown func once it's constructed, so callers can
+// drain it alongside other components; Listen itself blocks until exitCh
+// receives a value or ListenAndServe fails.
+func Listen(bindAddr string, assetFn func(string) ([]byte, error), authFile string, exitCh chan int, timeouts Timeouts, cb func(http.Handler), ready func(func(context.Context) error)) {
+	r := pat.New()
+	cb(r)
+	r.PathPrefix("/").Handler(assetHandler(assetFn))
+
+	var h http.Handler = r
+	if authFile != "" {
+		creds, err := loadAuthFile(authFile)
+		if err != nil {
+			log.Fatalf("[server] failed to load auth file %s: %s", authFile, err)
+			return
+		}
+		h = requireBasicAuth(h, creds)
+	}
+
+	srv := &http.Server{
+		Addr:         bindAddr,
+		Handler:      h,
+		ReadTimeout:  timeouts.ReadTimeout,
+		WriteTimeout: timeouts.WriteTimeout,
+		IdleTimeout:  timeouts.IdleTimeout,
+	}
+
+	ready(srv.Shutdown)
+
+	go func() {
+		<-exitCh
+		srv.Close()
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("[server] listen failed: %s", err)
+	}
+}
+
+// assetHandler serves a single embedded UI file per request path, falling
+// back to index.html at the root so client-side routing keeps working.
+func assetHandler(assetFn func(string) ([]byte, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := strings.TrimPrefix(req.URL.Path, "/")
+		if name == "" {
+			name = "index.html"
+		}
+		b, err := assetFn(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+func requireBasicAuth(h http.Handler, creds map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, pass, ok := req.BasicAuth()
+		hash, known := creds[user]
+		if !ok || !known || bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="MailHog"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func loadAuthFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		creds[parts[0]] = parts[1]
+	}
+	return creds, nil
+}
@@ -0,0 +1,79 @@
+// Package smtp accepts inbound mail on conf.SMTPBindAddr and makes it
+// available to the rest of MailHog-Server: each message is persisted
+// through conf.Storage, classified with conf.NamespaceExtractor exactly
+// the same way the broadcast path (websockets.Hub) classifies it, and
+// handed to conf.MessageChan for the API layer to fan out. Keeping intake
+// and broadcast on the same Extractor is what lets stored and streamed
+// messages agree on which namespace a message belongs to.
+package smtp
+
+import (
+	"net"
+
+	"github.com/bobrnor/MailHog-Server/config"
+	"github.com/bobrnor/storage"
+	"github.com/ian-kent/go-log/log"
+	"github.com/mailhog/data"
+	gosmtp "github.com/mailhog/smtp"
+)
+
+// Listen accepts SMTP connections on conf.SMTPBindAddr until exitCh
+// receives a value, handling each connection in its own goroutine.
+func Listen(conf *config.Config, exitCh chan int) {
+	ln, err := net.Listen("tcp", conf.SMTPBindAddr)
+	if err != nil {
+		log.Fatalf("[smtp] failed to listen on %s: %s", conf.SMTPBindAddr, err)
+		return
+	}
+
+	go func() {
+		<-exitCh
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Printf("[smtp] accept failed, stopping listener: %s", err)
+			return
+		}
+		go accept(conn, conf)
+	}
+}
+
+// accept drives a single SMTP session to completion, storing and
+// broadcasting every message it receives.
+func accept(conn net.Conn, conf *config.Config) {
+	proto := gosmtp.NewProtocol()
+	proto.Hostname = conf.Hostname
+	proto.MessageReceivedHandler = func(m *gosmtp.Message) (string, error) {
+		msg := m.Parse(conf.Hostname)
+		id := store(conf, msg)
+		conf.MessageChan <- msg
+		return id, nil
+	}
+
+	gosmtp.Accept(conn.RemoteAddr().String(), conn, proto)
+}
+
+// store persists msg through conf.Storage, routing it to the namespace
+// conf.NamespaceExtractor derives for it when Storage supports
+// per-namespace writes, and falling back to an unscoped write otherwise
+// so single-tenant deployments without a namespace-aware Storage keep
+// working unchanged.
+func store(conf *config.Config, msg *data.Message) string {
+	if s, ok := conf.Storage.(storage.StorageWithNamespace); ok {
+		ns := conf.NamespaceExtractor.Namespace(msg)
+		id, err := s.StoreWithNamespace(ns, msg)
+		if err != nil {
+			log.Printf("[smtp] failed to store message in namespace %q: %s", ns, err)
+		}
+		return id
+	}
+
+	id, err := conf.Storage.Store(msg)
+	if err != nil {
+		log.Printf("[smtp] failed to store message: %s", err)
+	}
+	return id
+}
@@ -3,10 +3,7 @@ package websockets
 import (
 	"time"
 
-	"encoding/json"
-
 	"github.com/gorilla/websocket"
-	"github.com/mailhog/data"
 )
 
 const (
@@ -20,16 +17,17 @@ const (
 	maxMessageSize = 1
 )
 
+// connection adapts a Hub subscription to a websocket, translating
+// entries arriving on the subscriber channel into WS frames.
 type connection struct {
-	namespace string
-	hub       *Hub
-	ws        *websocket.Conn
-	send      chan interface{}
+	ws      *websocket.Conn
+	entries <-chan Entry
+	cancel  func()
 }
 
 func (c *connection) readLoop() {
 	defer func() {
-		c.hub.unregisterChan <- c
+		c.cancel()
 		c.ws.Close()
 	}()
 	c.ws.SetReadLimit(maxMessageSize)
@@ -42,31 +40,39 @@ func (c *connection) readLoop() {
 	}
 }
 
-func (c *connection) writeLoop() {
+// writeLoop sends replay (buffered entries the client missed) before
+// tailing live entries from the hub until the connection is closed or
+// unregistered. Because the caller subscribes before taking the replay
+// snapshot (to avoid a gap), an entry broadcast in between can show up in
+// both replay and the live channel; lastReplayed drops that duplicate.
+func (c *connection) writeLoop(replay []Entry) {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.ws.Close()
 	}()
+
+	var lastReplayed int64
+	for _, e := range replay {
+		if err := c.writeJSON(e); err != nil {
+			return
+		}
+		lastReplayed = e.Sequence
+	}
+
 	for {
 		select {
-		case message, ok := <-c.send:
+		case e, ok := <-c.entries:
 			if !ok {
 				c.writeControl(websocket.CloseMessage)
 				return
 			}
 
-			msg, ok := message.(*data.Message)
-			if !ok {
-				return
-			}
-
-			ns := c.fetchNamespace(msg)
-			if ns != c.namespace {
-				return
+			if e.Sequence <= lastReplayed {
+				continue
 			}
 
-			if err := c.writeJSON(msg); err != nil {
+			if err := c.writeJSON(e); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -77,25 +83,6 @@ func (c *connection) writeLoop() {
 	}
 }
 
-func (c *connection) fetchNamespace(msg *data.Message) string {
-	xFields, ok := msg.Content.Headers["X-Fields"]
-	if !ok && len(xFields) == 0 {
-		return ""
-	}
-
-	xField := xFields[0]
-
-	var xFieldJson struct {
-		Microservice string `json:"ms"`
-	}
-
-	if err := json.Unmarshal([]byte(xField), &xFieldJson); err != nil {
-		return ""
-	}
-
-	return xFieldJson.Microservice
-}
-
 func (c *connection) writeJSON(message interface{}) error {
 	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
 	return c.ws.WriteJSON(message)
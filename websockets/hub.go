@@ -0,0 +1,218 @@
+package websockets
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bobrnor/MailHog-Server/namespace"
+	"github.com/gorilla/websocket"
+	"github.com/ian-kent/go-log/log"
+	"github.com/mailhog/data"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// subscriber receives broadcast entries for a single namespace. It's the
+// registration unit shared by the websocket and SSE transports, so both
+// see exactly the same filtered stream.
+type subscriber struct {
+	namespace string
+	send      chan Entry
+}
+
+// Hub maintains the set of active subscribers and fans out broadcast
+// messages to the ones subscribed to the relevant namespace. Every
+// broadcast is also appended to a per-namespace message log so new
+// subscribers can replay what they missed.
+type Hub struct {
+	subscribers    map[*subscriber]bool
+	broadcastChan  chan Entry
+	registerChan   chan *subscriber
+	unregisterChan chan *subscriber
+
+	logsMu sync.Mutex
+	logs   map[string]*messageLog
+
+	connsMu sync.Mutex
+	conns   map[*connection]bool
+	connsWg sync.WaitGroup
+
+	extractor namespace.Extractor
+
+	walDir    string
+	retention int64
+}
+
+// NewHub creates a Hub and starts its run loop. extractor determines which
+// namespace an incoming broadcast belongs to. Per-namespace message logs
+// are stored under walDir and each keeps at most retention entries.
+func NewHub(extractor namespace.Extractor, walDir string, retention int64) *Hub {
+	h := &Hub{
+		subscribers:    make(map[*subscriber]bool),
+		broadcastChan:  make(chan Entry),
+		registerChan:   make(chan *subscriber),
+		unregisterChan: make(chan *subscriber),
+		logs:           make(map[string]*messageLog),
+		conns:          make(map[*connection]bool),
+		extractor:      extractor,
+		walDir:         walDir,
+		retention:      retention,
+	}
+	go h.run()
+	return h
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case s := <-h.registerChan:
+			h.subscribers[s] = true
+		case s := <-h.unregisterChan:
+			if _, ok := h.subscribers[s]; ok {
+				delete(h.subscribers, s)
+				close(s.send)
+			}
+		case e := <-h.broadcastChan:
+			ns := h.extractor.Namespace(e.Message)
+			for s := range h.subscribers {
+				if s.namespace != ns {
+					continue
+				}
+				select {
+				case s.send <- e:
+				default:
+					delete(h.subscribers, s)
+					close(s.send)
+				}
+			}
+		}
+	}
+}
+
+// Broadcast appends msg to the log for its namespace and fans it out to
+// every subscriber of that namespace.
+func (h *Hub) Broadcast(msg *data.Message) {
+	ns := h.extractor.Namespace(msg)
+	seq := h.logFor(ns).append(msg)
+	h.broadcastChan <- Entry{Sequence: seq, Message: msg}
+}
+
+// Since returns every message broadcast to ns with a Sequence greater
+// than since, oldest first, so REST pollers, SSE and websocket clients
+// all share the same cursor semantics.
+func (h *Hub) Since(ns string, since int64) []Entry {
+	return h.logFor(ns).since(since)
+}
+
+func (h *Hub) logFor(ns string) *messageLog {
+	h.logsMu.Lock()
+	defer h.logsMu.Unlock()
+
+	l, ok := h.logs[ns]
+	if !ok {
+		l = newMessageLog(ns, h.walDir, h.retention)
+		h.logs[ns] = l
+	}
+	return l
+}
+
+// Subscribe registers a new live subscriber for ns and returns the
+// channel it will receive entries on along with a cancel func to
+// unregister it. It does not replay buffered history; callers that need
+// a resume point should call Since afterward, as ServeWithNamespace and
+// the SSE stream handler do, so nothing broadcast between the two calls
+// falls in the gap and gets silently dropped.
+func (h *Hub) Subscribe(ns string) (<-chan Entry, func()) {
+	s := &subscriber{namespace: ns, send: make(chan Entry, 256)}
+	h.registerChan <- s
+	return s.send, func() { h.unregisterChan <- s }
+}
+
+// ServeWithNamespace upgrades req to a websocket connection subscribed to
+// ns. If the client supplies ?since=<seq>, every buffered message with a
+// Sequence greater than since is replayed before the connection starts
+// tailing live broadcasts.
+func (h *Hub) ServeWithNamespace(ns string, w http.ResponseWriter, req *http.Request) {
+	ws, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	since := int64(0)
+	if s := req.URL.Query().Get("since"); s != "" {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			since = n
+		}
+	}
+
+	// Subscribe before snapshotting replay via Since: a broadcast landing
+	// in the gap between the two would otherwise fall into neither the
+	// replay nor the live tail. This can instead deliver an entry twice
+	// (once in replay, once live); writeLoop drops that duplicate rather
+	// than risk a gap.
+	entries, cancel := h.Subscribe(ns)
+	replay := h.Since(ns, since)
+
+	c := &connection{ws: ws, entries: entries, cancel: cancel}
+
+	h.connsMu.Lock()
+	h.conns[c] = true
+	h.connsMu.Unlock()
+	defer func() {
+		h.connsMu.Lock()
+		delete(h.conns, c)
+		h.connsMu.Unlock()
+	}()
+
+	h.connsWg.Add(1)
+	go func() {
+		defer h.connsWg.Done()
+		c.writeLoop(replay)
+	}()
+
+	c.readLoop()
+}
+
+// Shutdown unregisters every websocket connection's subscription (closing
+// its entry channel and letting writeLoop drain), waits for writeLoop to
+// finish or ctx to expire, then writes a CloseGoingAway control frame to
+// each connection and closes it. A writeLoop that sees its channel closed
+// has already sent a close frame with no status code and closed the
+// connection itself, so the CloseGoingAway frame only reaches connections
+// whose writeLoop was still running when ctx expired.
+func (h *Hub) Shutdown(ctx context.Context) {
+	h.connsMu.Lock()
+	conns := make([]*connection, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.connsMu.Unlock()
+
+	for _, c := range conns {
+		c.cancel()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		h.connsWg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	}
+
+	for _, c := range conns {
+		c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeWait))
+		c.ws.Close()
+	}
+}
@@ -0,0 +1,137 @@
+package websockets
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/ian-kent/go-log/log"
+	"github.com/mailhog/data"
+	"github.com/tidwall/wal"
+)
+
+// unsafeNamespaceChars matches everything that isn't safe to use verbatim
+// as a single path segment, so a namespace like "../../etc" can't escape
+// dir when it's joined into a filesystem path below.
+var unsafeNamespaceChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// sanitizeNamespace makes namespace safe to use as a single path segment
+// under dir. namespace comes from untrusted input: the {namespace} URL
+// segment of the messages?since=, stream and websocket routes, and the
+// headers or recipients of incoming mail via Hub.Broadcast, so it must
+// never be joined into a filesystem path unchecked.
+func sanitizeNamespace(namespace string) string {
+	safe := unsafeNamespaceChars.ReplaceAllString(namespace, "_")
+	if safe == "" || safe == "." || safe == ".." {
+		safe = "_"
+	}
+	return safe
+}
+
+// Entry is a single buffered broadcast, tagged with the monotonically
+// increasing sequence number clients use as a replay cursor.
+type Entry struct {
+	Sequence int64         `json:"sequence"`
+	Message  *data.Message `json:"message"`
+}
+
+// messageLog is a per-namespace append-only ring buffer backed by a
+// segmented write-ahead log, so buffered messages survive a restart.
+type messageLog struct {
+	mu        sync.Mutex
+	log       *wal.Log
+	retention int64
+}
+
+// newMessageLog opens (or creates) the write-ahead log for namespace under
+// dir, keeping at most retention entries. namespace is sanitized before
+// it's used as a path segment, since it comes straight from request input.
+func newMessageLog(namespace, dir string, retention int64) *messageLog {
+	l, err := wal.Open(filepath.Join(dir, sanitizeNamespace(namespace)), wal.DefaultOptions)
+	if err != nil {
+		log.Printf("[websockets] failed to open message log for namespace %s: %s", namespace, err)
+		return &messageLog{retention: retention}
+	}
+	return &messageLog{log: l, retention: retention}
+}
+
+// append writes msg to the log and returns its assigned sequence number.
+func (m *messageLog) append(msg *data.Message) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.log == nil {
+		return 0
+	}
+
+	seq, err := m.log.LastIndex()
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	seq++
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+
+	if err := m.log.Write(seq, b); err != nil {
+		log.Println(err)
+		return 0
+	}
+
+	m.truncate()
+
+	return int64(seq)
+}
+
+func (m *messageLog) truncate() {
+	last, err := m.log.LastIndex()
+	if err != nil || int64(last) <= m.retention {
+		return
+	}
+	if err := m.log.TruncateFront(last - uint64(m.retention) + 1); err != nil {
+		log.Println(err)
+	}
+}
+
+// since returns every buffered entry with Sequence > seq, oldest first.
+func (m *messageLog) since(seq int64) []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.log == nil {
+		return nil
+	}
+
+	first, err := m.log.FirstIndex()
+	if err != nil {
+		return nil
+	}
+	last, err := m.log.LastIndex()
+	if err != nil {
+		return nil
+	}
+
+	start := first
+	if uint64(seq+1) > start {
+		start = uint64(seq + 1)
+	}
+
+	var entries []Entry
+	for i := start; i <= last; i++ {
+		b, err := m.log.Read(i)
+		if err != nil {
+			continue
+		}
+		var msg data.Message
+		if err := json.Unmarshal(b, &msg); err != nil {
+			continue
+		}
+		entries = append(entries, Entry{Sequence: int64(i), Message: &msg})
+	}
+	return entries
+}